@@ -0,0 +1,34 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	backoffBase = 500 * time.Millisecond
+	backoffMax  = 30 * time.Second
+)
+
+// backoffDuration returns how long to wait after lk's most recent attempt
+// before it's worth re-popping from the queue: a server's Retry-After when
+// one was given, otherwise exponential backoff with jitter.
+func backoffDuration(lk *Link) time.Duration {
+	if len(lk.errs) == 0 {
+		return 0
+	}
+	if lk.retryAfter > 0 {
+		return lk.retryAfter
+	}
+
+	d := backoffBase * time.Duration(int64(1)<<uint(len(lk.errs)-1))
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// readyAt is the earliest time lk should be retried.
+func readyAt(lk *Link) time.Time {
+	return lk.lastFetch.Add(backoffDuration(lk))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffDurationNoErrors(t *testing.T) {
+	lk := &Link{}
+	if d := backoffDuration(lk); d != 0 {
+		t.Errorf("backoffDuration() = %v, want 0 for a link with no errors", d)
+	}
+}
+
+func TestBackoffDurationHonorsRetryAfter(t *testing.T) {
+	lk := &Link{errs: []error{errors.New("boom")}, retryAfter: 7 * time.Second}
+	if d := backoffDuration(lk); d != 7*time.Second {
+		t.Errorf("backoffDuration() = %v, want the server's Retry-After of 7s", d)
+	}
+}
+
+func TestBackoffDurationGrowsAndCaps(t *testing.T) {
+	one := &Link{errs: []error{errors.New("1")}}
+	many := &Link{errs: make([]error, 10)}
+	for i := range many.errs {
+		many.errs[i] = errors.New("err")
+	}
+
+	// Jitter only adds on top, so the base (pre-jitter) exponential backoff
+	// is a lower bound; after enough attempts it must have hit the cap.
+	if d := backoffDuration(many); d < backoffMax {
+		t.Errorf("backoffDuration() after 10 attempts = %v, want at least the %v cap", d, backoffMax)
+	}
+	if d := backoffDuration(one); d < backoffBase {
+		t.Errorf("backoffDuration() after 1 attempt = %v, want at least the %v base", d, backoffBase)
+	}
+}
+
+func TestReadyAt(t *testing.T) {
+	now := time.Now()
+	lk := &Link{errs: []error{errors.New("boom")}, retryAfter: 5 * time.Second, lastFetch: now}
+	if got, want := readyAt(lk), now.Add(5*time.Second); !got.Equal(want) {
+		t.Errorf("readyAt() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import "regexp"
+
+var cssURLPattern = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+
+// extractCSSURLs returns the raw url(...) references found in a CSS source,
+// in the order they appear, for inline <style> blocks and fetched
+// text/css responses alike.
+func extractCSSURLs(css string) []string {
+	matches := cssURLPattern.FindAllStringSubmatch(css, -1)
+	urls := make([]string, 0, len(matches))
+	for _, m := range matches {
+		urls = append(urls, m[1])
+	}
+	return urls
+}
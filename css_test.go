@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCSSURLs(t *testing.T) {
+	css := `
+		body { background: url(bg.png); }
+		.a { background-image: url("a/b.png"); }
+		.b { background-image: url('c.png'); }
+		.c { background: url( d.png ); }
+	`
+	got := extractCSSURLs(css)
+	want := []string{"bg.png", "a/b.png", "c.png", "d.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractCSSURLs() = %v, want %v", got, want)
+	}
+}
+
+func TestExtractCSSURLsNone(t *testing.T) {
+	if got := extractCSSURLs("body { color: red; }"); len(got) != 0 {
+		t.Fatalf("extractCSSURLs() = %v, want none", got)
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	got := parseSrcset("small.jpg 480w, medium.jpg 800w, large.jpg 2x")
+	want := []string{"small.jpg", "medium.jpg", "large.jpg"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseSrcset() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSrcsetEmpty(t *testing.T) {
+	if got := parseSrcset(""); got != nil {
+		t.Fatalf("parseSrcset(\"\") = %v, want nil", got)
+	}
+}
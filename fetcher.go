@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// errTransient marks a fetch error worth retrying (5xx responses, or a
+// transport-level error such as a reset or timeout), so the scheduler can
+// tell it apart from a permanent failure like a 404 and only back off and
+// retry the former.
+var errTransient = errors.New("transient fetch error")
+
+// Fetcher performs the HTTP GET for a Link. worker.down and worker.scrape
+// share one Fetcher instead of calling http.Get directly, so conditional
+// requests, transient-error classification and content hashing live in a
+// single place. Fetch makes one attempt: retrying is up to the scheduler,
+// and deduplicating identical bodies is up to the caller.
+type Fetcher interface {
+	// Fetch returns the response and its body. A nil body with a nil error
+	// means the server answered 304 Not Modified: the caller already has
+	// the current content from a prior crawl.
+	Fetch(lk *Link) (*http.Response, []byte, error)
+}
+
+// httpFetcher is the default Fetcher. When state is set, it sends
+// If-None-Match/If-Modified-Since from a prior crawl's stored ETag/
+// Last-Modified, and records each successful response's ETag/Last-Modified/
+// SHA-256 back to state for the next run (304 and error responses are not
+// recorded).
+type httpFetcher struct {
+	userAgent string
+	warc      *warcWriter
+	state     *crawlState
+}
+
+func newHTTPFetcher(userAgent string, warc *warcWriter, state *crawlState) *httpFetcher {
+	return &httpFetcher{userAgent: userAgent, warc: warc, state: state}
+}
+
+func (f *httpFetcher) Fetch(lk *Link) (*http.Response, []byte, error) {
+	lk.retryAfter = 0
+
+	req, err := http.NewRequest(http.MethodGet, lk.url.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+
+	if f.state != nil {
+		if rec, found, _ := f.state.get(lk.url.String()); found {
+			if rec.ETag != "" {
+				req.Header.Set("If-None-Match", rec.ETag)
+			}
+			if !rec.LastModified.IsZero() {
+				req.Header.Set("If-Modified-Since", rec.LastModified.UTC().Format(http.TimeFormat))
+			}
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errTransient, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp, nil, nil
+	}
+
+	if resp.StatusCode >= 500 {
+		lk.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		return resp, nil, fmt.Errorf("%w: %s", errTransient, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errTransient, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return resp, nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	if f.warc != nil {
+		if err := f.warc.writeExchange(lk.url.String(), req, resp, body); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if f.state != nil {
+		hash := sha256.Sum256(body)
+		err := f.state.recordFetch(lk.url.String(), resp.Header.Get("ETag"),
+			parseLastModified(resp.Header.Get("Last-Modified")), hex.EncodeToString(hash[:]))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return resp, body, nil
+}
+
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func parseLastModified(v string) time.Time {
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"os"
@@ -27,16 +30,47 @@ const (
 	DEBUG      = false
 )
 
+// LinkTag classifies a Link as either driving further recursion (TagPrimary,
+// e.g. anchors and frames) or as an asset that belongs to the page that
+// referenced it (TagRelated, e.g. stylesheets, scripts, images). Related
+// links are always fetched alongside an in-scope page, even when hosted on a
+// different domain such as a CDN.
+type LinkTag int
+
+const (
+	TagPrimary LinkTag = iota
+	TagRelated
+)
+
 var recurse = flag.Bool("recurse", false, "recurse into linked pages with same domain")
 var dryrun = flag.Bool("dryrun", false, "dry run")
 var output = flag.String("output", "", "output directory (has to exist)")
+var mirror = flag.Bool("mirror", false, "mirror the site under -output using its own URL structure, rewriting links for offline browsing")
+var warc = flag.String("warc", "", "write fetched requests/responses as WARC records to this .warc.gz file instead of -output")
+var stateDir = flag.String("state", "", "directory for persistent, resumable crawl state (has to exist)")
+var userAgent = flag.String("user-agent", "scraper-bot", "User-Agent sent with requests and used to evaluate robots.txt")
+var depthFlag = flag.Int("depth", -1, "max recursion depth for non-leaf links (-1 = unlimited)")
+var scopeFlag = flag.String("scope", "samehost", "link scope: samehost, samedomain, prefix:<p>, or regexp:<pattern>")
+
+// globalRobots and globalLimiter are consulted by the scheduler when a link
+// is discovered and when it is dispatched, and globalScope by workers when
+// they resolve a link, so every worker shares the same per-host robots.txt
+// cache, rate limit and scope.
+var globalRobots *robotsCache
+var globalLimiter = newHostLimiter()
+var globalScope Scope
+var globalMirror = newMirrorManifest()
 
 type Link struct {
-	url       *url.URL
-	errs      []error
-	lastFetch time.Time
-	isLeaf    bool
-	index     int
+	url        *url.URL
+	errs       []error
+	lastFetch  time.Time
+	isLeaf     bool
+	tag        LinkTag
+	depth      int
+	failed     bool          // whether the most recent process() call errored
+	retryAfter time.Duration // server-requested retry delay from the last failed fetch, if any
+	index      int
 }
 
 type LinkQueue []*Link
@@ -44,6 +78,16 @@ type LinkQueue []*Link
 func (lq LinkQueue) Len() int { return len(lq) }
 
 func (lq LinkQueue) Less(i, j int) bool {
+	now := time.Now()
+	iReady, jReady := !now.Before(readyAt(lq[i])), !now.Before(readyAt(lq[j]))
+	if iReady != jReady {
+		return iReady
+	}
+
+	ic, jc := globalLimiter.hasCapacity(lq[i].url.Host), globalLimiter.hasCapacity(lq[j].url.Host)
+	if ic != jc {
+		return ic
+	}
 	return lq[i].lastFetch.Before(lq[j].lastFetch) && len(lq[i].errs) < len(lq[j].errs)
 }
 
@@ -70,13 +114,14 @@ func (lq *LinkQueue) Pop() interface{} {
 }
 
 type scheduler struct {
-	wg   *sync.WaitGroup
-	seen map[string]struct{}
-	lq   LinkQueue
-	wc   chan *Link
-	ec   chan *Link
-	sc   chan *Link
-	pc   chan *Link
+	wg    *sync.WaitGroup
+	seen  map[string]struct{}
+	state *crawlState
+	lq    LinkQueue
+	wc    chan *Link
+	ec    chan *Link
+	sc    chan *Link
+	pc    chan *Link
 }
 
 func (sch *scheduler) run() {
@@ -85,24 +130,74 @@ func (sch *scheduler) run() {
 	for {
 		select {
 		case lk := <-sch.ec:
-			if len(lk.errs) < maxTries {
+			transient := errors.Is(lk.errs[len(lk.errs)-1], errTransient)
+			if transient && len(lk.errs) < maxTries {
 				heap.Push(&sch.lq, lk)
 			} else {
 				fmt.Printf("failed to process %s with errors %v\n", lk.url.String(), lk.errs[0])
 				numInflight--
+				if sch.state != nil {
+					if err := sch.state.markFailed(lk.url.String()); err != nil {
+						fmt.Printf("state error for %s: %v\n", lk.url.String(), err)
+					}
+				}
 			}
 		case lk := <-sch.sc:
-			if _, seen := sch.seen[lk.url.String()]; !seen {
-				heap.Push(&sch.lq, lk)
-				sch.seen[lk.url.String()] = struct{}{}
+			if globalRobots != nil && !globalRobots.allowed(lk.url) {
+				fmt.Printf("dropping %s: disallowed by robots.txt\n", lk.url.String())
+			} else if !lk.isLeaf && *depthFlag >= 0 && lk.depth > *depthFlag {
+				fmt.Printf("dropping %s: exceeds max depth %d\n", lk.url.String(), *depthFlag)
+			} else {
+				if globalRobots != nil {
+					globalLimiter.setCrawlDelay(lk.url.Host, globalRobots.crawlDelayFor(lk.url))
+				}
+				if sch.state != nil {
+					queued, err := sch.state.markQueued(lk)
+					if err != nil {
+						fmt.Printf("state error for %s: %v\n", lk.url.String(), err)
+					} else if queued {
+						heap.Push(&sch.lq, lk)
+					}
+				} else if _, seen := sch.seen[lk.url.String()]; !seen {
+					heap.Push(&sch.lq, lk)
+					sch.seen[lk.url.String()] = struct{}{}
+				}
 			}
-		case <-sch.pc:
+		case lk := <-sch.pc:
 			numInflight--
+			if !lk.failed && sch.state != nil {
+				if err := sch.state.markDone(lk.url.String()); err != nil {
+					fmt.Printf("state error for %s: %v\n", lk.url.String(), err)
+				}
+			}
 		default:
 		}
 
 		for numInflight < numWorkers && sch.lq.Len() > 0 {
 			lk := heap.Pop(&sch.lq).(*Link)
+			if wait := time.Until(readyAt(lk)); wait > 0 {
+				heap.Push(&sch.lq, lk)
+				if numInflight == 0 {
+					// Nothing else is in flight, so the crawl would end below
+					// with lk still queued: wait out its backoff instead.
+					time.Sleep(wait)
+					continue
+				}
+				break
+			}
+			if !globalLimiter.take(lk.url.Host) {
+				heap.Push(&sch.lq, lk)
+				if numInflight == 0 {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				break
+			}
+			if sch.state != nil {
+				if err := sch.state.markInFlight(lk.url.String()); err != nil {
+					fmt.Printf("state error for %s: %v\n", lk.url.String(), err)
+				}
+			}
 			sch.wc <- lk
 			numInflight++
 		}
@@ -123,6 +218,16 @@ type worker struct {
 	pc          chan *Link
 	mainUrl     *url.URL
 	mainBaseUrl *url.URL
+	warc        *warcWriter
+	state       *crawlState
+	fetcher     Fetcher
+}
+
+// fetch delegates to w.fetcher, shared by down and scrape so conditional
+// requests, transient-error classification and WARC archival all live in
+// one place. Retries are left to the scheduler.
+func (w *worker) fetch(lk *Link) (*http.Response, []byte, error) {
+	return w.fetcher.Fetch(lk)
 }
 
 func (w *worker) down(lk *Link) error {
@@ -130,51 +235,83 @@ func (w *worker) down(lk *Link) error {
 		fmt.Printf("down(%s)\n", lk.url.String())
 		return nil
 	}
-	fid := uuid.NewString()
+
+	resp, body, err := w.fetch(lk)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		// 304 Not Modified: we already have this content from a prior crawl.
+		return nil
+	}
+
+	if isCSS(resp, lk.url) {
+		if err := w.extractCSSLinks(body, lk.depth); err != nil {
+			return err
+		}
+	}
+
+	if w.warc != nil {
+		// archival only: the WARC file is the output, nothing else to write.
+		return nil
+	}
+
+	if *mirror {
+		return w.saveMirror(lk, resp, body)
+	}
+
 	filename := path.Base(lk.url.Path)
 	if filename == "" {
 		return fmt.Errorf("Failed to derive file name from %v", lk.url)
 	}
+
+	if w.state != nil {
+		hash := sha256.Sum256(body)
+		hashStr := hex.EncodeToString(hash[:])
+		claimed, err := w.state.claimHash(hashStr)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			return w.state.recordFetch(lk.url.String(), resp.Header.Get("ETag"),
+				parseLastModified(resp.Header.Get("Last-Modified")), hashStr)
+		}
+
+		ext := path.Ext(filename)
+		filename = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filename, ext), hashStr, ext)
+
+		if err := w.writeFile(filename, body); err != nil {
+			return err
+		}
+		return w.state.recordHashPath(hashStr, filename)
+	}
+
+	fid := uuid.NewString()
 	ext := path.Ext(filename)
 	filename = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filename, ext), fid, ext)
+	return w.writeFile(filename, body)
+}
 
+// writeFile saves body under *output as filename.
+func (w *worker) writeFile(filename string, body []byte) error {
 	out, err := os.Create(filepath.Join(*output, filename))
 	if err != nil {
 		return err
 	}
 	defer out.Close()
 
-	resp, err := http.Get(lk.url.String())
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return err
-	}
-	return nil
+	_, err = out.Write(body)
+	return err
 }
 
-func (w *worker) extractLink(n *html.Node) (*Link, error) {
-	var urlStr string
-	isLeaf := false
-
-	if n.DataAtom == atom.A {
-		if !*recurse {
-			return nil, nil
-		}
-		urlStr = scrape.Attr(n, "href")
-	} else if n.DataAtom == atom.Img {
-		urlStr = scrape.Attr(n, "src")
-		if urlStr == "" {
-			urlStr = scrape.Attr(n, "data-src")
-		}
-		isLeaf = true
-	} else if n.DataAtom == atom.Frame {
-		urlStr = scrape.Attr(n, "src")
-	} else {
+// resolve turns a raw attribute value into a Link relative to the site being
+// crawled, or (nil, nil) when the link should be dropped: empty values, and
+// out-of-scope TagPrimary links (related assets bypass Scope entirely, even
+// when served from a different host such as a CDN). Non-leaf links get a
+// depth one past their referring page; leaves inherit their page's depth,
+// since they don't themselves drive recursion.
+func (w *worker) resolve(urlStr string, tag LinkTag, isLeaf bool, parentDepth int) (*Link, error) {
+	if urlStr == "" {
 		return nil, nil
 	}
 	if strings.HasPrefix(urlStr, "/") {
@@ -186,7 +323,7 @@ func (w *worker) extractLink(n *html.Node) (*Link, error) {
 		return nil, err
 	}
 
-	if !isLeaf && u.IsAbs() && u.Host != w.mainUrl.Host {
+	if tag == TagPrimary && u.IsAbs() && !globalScope.InScope(u) {
 		return nil, nil
 	}
 
@@ -201,35 +338,164 @@ func (w *worker) extractLink(n *html.Node) (*Link, error) {
 		fmt.Printf("extracting link from %s to %s\n", urlStr, u.String())
 	}
 
+	depth := parentDepth
+	if !isLeaf {
+		depth = parentDepth + 1
+	}
+
 	return &Link{
 		url:    u,
 		isLeaf: isLeaf,
+		tag:    tag,
+		depth:  depth,
 	}, nil
 }
 
+func (w *worker) resolveOne(urlStr string, tag LinkTag, isLeaf bool, parentDepth int) ([]*Link, error) {
+	lk, err := w.resolve(urlStr, tag, isLeaf, parentDepth)
+	if err != nil || lk == nil {
+		return nil, err
+	}
+	return []*Link{lk}, nil
+}
+
+func (w *worker) resolveMany(urlStrs []string, tag LinkTag, isLeaf bool, parentDepth int) ([]*Link, error) {
+	var links []*Link
+	for _, s := range urlStrs {
+		lk, err := w.resolve(s, tag, isLeaf, parentDepth)
+		if err != nil {
+			return nil, err
+		}
+		if lk != nil {
+			links = append(links, lk)
+		}
+	}
+	return links, nil
+}
+
+// extractLinks pulls every Link referenced by n: anchors and frames drive
+// further recursion (TagPrimary), while stylesheets, scripts, media and
+// inline CSS are related assets (TagRelated) that are fetched whenever the
+// referring page is in scope. parentDepth is the depth of the page n was
+// found on.
+func (w *worker) extractLinks(n *html.Node, parentDepth int) ([]*Link, error) {
+	switch n.DataAtom {
+	case atom.A:
+		if !*recurse {
+			return nil, nil
+		}
+		return w.resolveOne(scrape.Attr(n, "href"), TagPrimary, false, parentDepth)
+	case atom.Img:
+		urlStr := scrape.Attr(n, "src")
+		if urlStr == "" {
+			urlStr = scrape.Attr(n, "data-src")
+		}
+		return w.resolveOne(urlStr, TagRelated, true, parentDepth)
+	case atom.Frame, atom.Iframe:
+		return w.resolveOne(scrape.Attr(n, "src"), TagPrimary, false, parentDepth)
+	case atom.Link:
+		rel := strings.ToLower(scrape.Attr(n, "rel"))
+		if rel != "stylesheet" && rel != "icon" && rel != "shortcut icon" {
+			return nil, nil
+		}
+		return w.resolveOne(scrape.Attr(n, "href"), TagRelated, true, parentDepth)
+	case atom.Script:
+		return w.resolveOne(scrape.Attr(n, "src"), TagRelated, true, parentDepth)
+	case atom.Source:
+		links, err := w.resolveOne(scrape.Attr(n, "src"), TagRelated, true, parentDepth)
+		if err != nil {
+			return nil, err
+		}
+		srcsetLinks, err := w.resolveMany(parseSrcset(scrape.Attr(n, "srcset")), TagRelated, true, parentDepth)
+		if err != nil {
+			return nil, err
+		}
+		return append(links, srcsetLinks...), nil
+	case atom.Video, atom.Audio:
+		return w.resolveOne(scrape.Attr(n, "src"), TagRelated, true, parentDepth)
+	case atom.Style:
+		return w.resolveMany(extractCSSURLs(scrape.Text(n)), TagRelated, true, parentDepth)
+	default:
+		return nil, nil
+	}
+}
+
+// extractCSSLinks applies the same CSS url(...) extraction used for inline
+// <style> blocks to a fetched text/css response body.
+func (w *worker) extractCSSLinks(body []byte, parentDepth int) error {
+	links, err := w.resolveMany(extractCSSURLs(string(body)), TagRelated, true, parentDepth)
+	if err != nil {
+		return err
+	}
+	for _, lk := range links {
+		w.sc <- lk
+	}
+	return nil
+}
+
+// isCSS reports whether resp looks like a stylesheet, by Content-Type or
+// file extension.
+func isCSS(resp *http.Response, u *url.URL) bool {
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/css") {
+		return true
+	}
+	return strings.HasSuffix(strings.ToLower(u.Path), ".css")
+}
+
+// parseSrcset splits a srcset attribute value into its candidate URLs,
+// discarding the width/density descriptors.
+func parseSrcset(val string) []string {
+	if val == "" {
+		return nil
+	}
+	parts := strings.Split(val, ",")
+	urls := make([]string, 0, len(parts))
+	for _, p := range parts {
+		fields := strings.Fields(strings.TrimSpace(p))
+		if len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
+
 func (w *worker) scrape(lk *Link) error {
-	resp, err := http.Get(lk.url.String())
+	resp, body, err := w.fetch(lk)
 	if err != nil {
 		return err
 	}
+	if body == nil {
+		// 304 Not Modified: nothing changed, so there are no new links to find.
+		return nil
+	}
 
-	root, err := html.Parse(resp.Body)
+	root, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
+	if *mirror {
+		if err := w.saveMirror(lk, resp, body); err != nil {
+			return err
+		}
+	}
+
 	matcher := func(n *html.Node) bool {
-		return n.DataAtom == atom.A || n.DataAtom == atom.Img || n.DataAtom == atom.Frame
+		switch n.DataAtom {
+		case atom.A, atom.Img, atom.Frame, atom.Iframe, atom.Link, atom.Script, atom.Source, atom.Video, atom.Audio, atom.Style:
+			return true
+		}
+		return false
 	}
 
 	nodes := scrape.FindAll(root, matcher)
 
 	for _, n := range nodes {
-		lk, err := w.extractLink(n)
+		links, err := w.extractLinks(n, lk.depth)
 		if err != nil {
 			return err
 		}
-		if lk != nil {
+		for _, lk := range links {
 			w.sc <- lk
 		}
 	}
@@ -256,7 +522,10 @@ func (w *worker) run() {
 		if err != nil {
 			lk.errs = append(lk.errs, err)
 			lk.lastFetch = time.Now()
+			lk.failed = true
 			w.ec <- lk
+		} else {
+			lk.failed = false
 		}
 		w.pc <- lk
 	}
@@ -285,6 +554,36 @@ func main() {
 	mainBaseUrl.RawQuery = ""
 	mainBaseUrl.Fragment = ""
 
+	globalRobots = newRobotsCache(*userAgent)
+
+	globalScope, err = newScope(*scopeFlag, mainUrl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -scope: %v\n", err)
+		os.Exit(1)
+	}
+
+	var ww *warcWriter
+	if *warc != "" {
+		ww, err = newWarcWriter(*warc)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open warc file %s: %v\n", *warc, err)
+			os.Exit(1)
+		}
+		defer ww.Close()
+	}
+
+	var cs *crawlState
+	if *stateDir != "" {
+		cs, err = openCrawlState(*stateDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open state dir %s: %v\n", *stateDir, err)
+			os.Exit(1)
+		}
+		defer cs.Close()
+	}
+
+	fetcher := newHTTPFetcher(*userAgent, ww, cs)
+
 	spn := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
 	spn.Start()
 
@@ -306,27 +605,52 @@ func main() {
 			wg:          &wg,
 			mainUrl:     mainUrl,
 			mainBaseUrl: mainBaseUrl,
+			warc:        ww,
+			state:       cs,
+			fetcher:     fetcher,
 		}
 		go w.run()
 	}
 
-	lq := make(LinkQueue, 1, 1024)
-	lq[0] = &Link{
-		url: mainUrl,
+	var lq LinkQueue
+	if cs != nil {
+		if _, err := cs.markQueued(&Link{url: mainUrl}); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to seed crawl state: %v\n", err)
+			os.Exit(1)
+		}
+		lq, err = cs.pending()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load crawl state: %v\n", err)
+			os.Exit(1)
+		}
+		heap.Init(&lq)
+	} else {
+		lq = make(LinkQueue, 1, 1024)
+		lq[0] = &Link{
+			url: mainUrl,
+		}
 	}
 
 	sch := &scheduler{
-		wc:   wc,
-		ec:   ec,
-		sc:   sc,
-		pc:   pc,
-		wg:   &wg,
-		lq:   lq,
-		seen: make(map[string]struct{}),
+		wc:    wc,
+		ec:    ec,
+		sc:    sc,
+		pc:    pc,
+		wg:    &wg,
+		lq:    lq,
+		seen:  make(map[string]struct{}),
+		state: cs,
 	}
 
 	go sch.run()
 
 	wg.Wait()
 	spn.Stop()
+
+	if *mirror {
+		if err := globalMirror.rewriteAll(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rewrite mirrored links: %v\n", err)
+			os.Exit(1)
+		}
+	}
 }
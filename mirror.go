@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// mirrorEntry records where a fetched URL ended up on disk, and what kind
+// of content it holds, so the post-crawl rewrite pass knows what to touch.
+type mirrorEntry struct {
+	localPath   string // relative to -output
+	contentType string // "html", "css", or "other"
+}
+
+// mirrorManifest maps every URL saved in -mirror mode to its local path, so
+// a second pass can rewrite href/src/url(...) references to point at local
+// files once the whole site has been discovered.
+type mirrorManifest struct {
+	mu      sync.Mutex
+	entries map[string]mirrorEntry
+}
+
+func newMirrorManifest() *mirrorManifest {
+	return &mirrorManifest{entries: make(map[string]mirrorEntry)}
+}
+
+func (m *mirrorManifest) record(urlStr, localPath, contentType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[urlStr] = mirrorEntry{localPath: localPath, contentType: contentType}
+}
+
+func (m *mirrorManifest) lookup(urlStr string) (mirrorEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[urlStr]
+	return e, ok
+}
+
+// mirrorPath computes where u should live under -output: a trailing slash
+// or empty path becomes index.html, an extensionless HTML path gets .html
+// appended, and a non-empty query string is folded into the filename (as
+// wget's mirror mode does) so two URLs differing only by query don't
+// collide on the same local file. The result is always confined to
+// u.Host's directory: ".." segments in u.Path cannot escape it.
+func mirrorPath(u *url.URL, isHTML bool) string {
+	trailingSlash := u.Path == "" || strings.HasSuffix(u.Path, "/")
+	p := path.Clean("/" + u.Path)
+	if u.RawQuery != "" {
+		if trailingSlash {
+			p = strings.TrimSuffix(p, "/") + "/index"
+			trailingSlash = false
+		}
+		p += "@" + sanitizeFilename(u.RawQuery)
+	}
+	switch {
+	case trailingSlash:
+		// path.Clean already stripped the trailing slash; put it back
+		// before appending the directory-index filename.
+		p = strings.TrimSuffix(p, "/") + "/index.html"
+	case isHTML && path.Ext(p) == "":
+		p += ".html"
+	}
+	// p is rooted and path.Clean has already collapsed any ".." segments,
+	// so joining it under u.Host can't escape that directory.
+	return filepath.Join(u.Host, filepath.FromSlash(p))
+}
+
+// sanitizeFilename replaces characters that are unsafe or meaningless in a
+// path segment (path separators, "..") with "_", for folding a query string
+// into a local filename.
+func sanitizeFilename(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(s)
+	return s
+}
+
+// sniffIsHTML reports whether resp/body look like an HTML document.
+func sniffIsHTML(resp *http.Response, body []byte) bool {
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(body)
+	}
+	return strings.Contains(ct, "text/html")
+}
+
+// saveMirror writes a fetched resource under -output using the site's own
+// URL structure and records it in the manifest for the post-crawl rewrite
+// pass. Called from both down (leaf assets) and scrape (pages): -mirror
+// needs pages saved too, not just recursed into for more links.
+func (w *worker) saveMirror(lk *Link, resp *http.Response, body []byte) error {
+	isHTML := sniffIsHTML(resp, body)
+	contentType := "other"
+	switch {
+	case isHTML:
+		contentType = "html"
+	case isCSS(resp, lk.url):
+		contentType = "css"
+	}
+
+	relPath := mirrorPath(lk.url, isHTML)
+	fullPath := filepath.Join(*output, relPath)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(fullPath, body, 0644); err != nil {
+		return err
+	}
+
+	globalMirror.record(lk.url.String(), relPath, contentType)
+	return nil
+}
+
+// rewriteAll rewrites every saved HTML/CSS file's references to point at
+// their local mirrored paths. Run once after the crawl has finished, since
+// only then is every URL's eventual local path known.
+func (m *mirrorManifest) rewriteAll() error {
+	for urlStr, entry := range m.entries {
+		if entry.contentType != "html" && entry.contentType != "css" {
+			continue
+		}
+
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return err
+		}
+
+		fullPath := filepath.Join(*output, entry.localPath)
+		body, err := os.ReadFile(fullPath)
+		if err != nil {
+			return err
+		}
+
+		var rewritten []byte
+		if entry.contentType == "html" {
+			rewritten, err = m.rewriteHTML(body, u, entry.localPath)
+			if err != nil {
+				return err
+			}
+		} else {
+			rewritten = m.rewriteCSS(body, u, entry.localPath)
+		}
+
+		if err := os.WriteFile(fullPath, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var mirrorAttrs = map[atom.Atom]string{
+	atom.A:      "href",
+	atom.Img:    "src",
+	atom.Link:   "href",
+	atom.Script: "src",
+	atom.Video:  "src",
+	atom.Audio:  "src",
+	atom.Frame:  "src",
+	atom.Iframe: "src",
+}
+
+func (m *mirrorManifest) rewriteHTML(body []byte, pageURL *url.URL, pageLocalPath string) ([]byte, error) {
+	root, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	matcher := func(n *html.Node) bool {
+		if _, ok := mirrorAttrs[n.DataAtom]; ok {
+			return true
+		}
+		return n.DataAtom == atom.Source || n.DataAtom == atom.Style
+	}
+
+	for _, n := range scrape.FindAll(root, matcher) {
+		switch {
+		case n.DataAtom == atom.Style:
+			if n.FirstChild != nil && n.FirstChild.Type == html.TextNode {
+				n.FirstChild.Data = m.rewriteCSSText(n.FirstChild.Data, pageURL, pageLocalPath)
+			}
+		case n.DataAtom == atom.Source:
+			m.rewriteAttr(n, "src", pageURL, pageLocalPath)
+			m.rewriteSrcset(n, pageURL, pageLocalPath)
+		default:
+			m.rewriteAttr(n, mirrorAttrs[n.DataAtom], pageURL, pageLocalPath)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *mirrorManifest) rewriteAttr(n *html.Node, attrName string, pageURL *url.URL, pageLocalPath string) {
+	for i, a := range n.Attr {
+		if a.Key != attrName {
+			continue
+		}
+		if rel, ok := m.localHref(a.Val, pageURL, pageLocalPath); ok {
+			n.Attr[i].Val = rel
+		}
+		return
+	}
+}
+
+func (m *mirrorManifest) rewriteSrcset(n *html.Node, pageURL *url.URL, pageLocalPath string) {
+	for i, a := range n.Attr {
+		if a.Key != "srcset" {
+			continue
+		}
+		parts := strings.Split(a.Val, ",")
+		for j, p := range parts {
+			fields := strings.Fields(strings.TrimSpace(p))
+			if len(fields) == 0 {
+				continue
+			}
+			if rel, ok := m.localHref(fields[0], pageURL, pageLocalPath); ok {
+				fields[0] = rel
+			}
+			parts[j] = strings.Join(fields, " ")
+		}
+		n.Attr[i].Val = strings.Join(parts, ", ")
+		return
+	}
+}
+
+func (m *mirrorManifest) rewriteCSS(body []byte, pageURL *url.URL, pageLocalPath string) []byte {
+	return []byte(m.rewriteCSSText(string(body), pageURL, pageLocalPath))
+}
+
+func (m *mirrorManifest) rewriteCSSText(css string, pageURL *url.URL, pageLocalPath string) string {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		sub := cssURLPattern.FindStringSubmatch(match)
+		if sub == nil {
+			return match
+		}
+		rel, ok := m.localHref(sub[1], pageURL, pageLocalPath)
+		if !ok {
+			return match
+		}
+		return fmt.Sprintf("url(%s)", rel)
+	})
+}
+
+// localHref resolves ref against pageURL, looks the result up in the
+// manifest, and returns a path relative to pageLocalPath's directory
+// suitable for an href/src/url() in the file living at pageLocalPath.
+func (m *mirrorManifest) localHref(ref string, pageURL *url.URL, pageLocalPath string) (string, bool) {
+	target, err := pageURL.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	target.Fragment = ""
+
+	entry, ok := m.lookup(target.String())
+	if !ok {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(pageLocalPath), entry.localPath)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
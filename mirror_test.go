@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorPathTrailingSlash(t *testing.T) {
+	u := mustParseURL(t, "https://example.com/docs/")
+	want := filepath.Join("example.com", "docs", "index.html")
+	if got := mirrorPath(u, true); got != want {
+		t.Errorf("mirrorPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMirrorPathExtensionlessHTML(t *testing.T) {
+	u := mustParseURL(t, "https://example.com/about")
+	want := filepath.Join("example.com", "about.html")
+	if got := mirrorPath(u, true); got != want {
+		t.Errorf("mirrorPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMirrorPathNonHTMLKeepsExtensionless(t *testing.T) {
+	u := mustParseURL(t, "https://example.com/logo")
+	want := filepath.Join("example.com", "logo")
+	if got := mirrorPath(u, false); got != want {
+		t.Errorf("mirrorPath() = %q, want %q", got, want)
+	}
+}
+
+func TestMirrorPathRejectsTraversal(t *testing.T) {
+	u := mustParseURL(t, "https://example.com/../../../../tmp/evil")
+	got := mirrorPath(u, false)
+	want := filepath.Join("example.com", "tmp", "evil")
+	if got != want {
+		t.Errorf("mirrorPath() = %q, want %q (traversal must be confined under the host dir)", got, want)
+	}
+}
+
+func TestMirrorPathDisambiguatesQueryString(t *testing.T) {
+	a := mirrorPath(mustParseURL(t, "https://example.com/list?page=2"), true)
+	b := mirrorPath(mustParseURL(t, "https://example.com/list?page=3"), true)
+	if a == b {
+		t.Errorf("mirrorPath() collapsed distinct query strings to the same path: %q", a)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultHostRate     = 4.0 // tokens per second
+	defaultHostCapacity = 4.0
+)
+
+// hostBucket is a token bucket limiting how often a single host may be hit,
+// refilled at a steady rate unless narrowed by that host's robots.txt
+// Crawl-delay.
+type hostBucket struct {
+	capacity float64
+	rate     float64 // tokens per second
+	tokens   float64
+	last     time.Time
+}
+
+func (b *hostBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+}
+
+// hostLimiter subdivides the crawler's global numWorkers concurrency across
+// hosts, so one slow or rate-limited host can't starve the rest of a crawl.
+type hostLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*hostBucket
+}
+
+func newHostLimiter() *hostLimiter {
+	return &hostLimiter{buckets: make(map[string]*hostBucket)}
+}
+
+func (hl *hostLimiter) bucketForLocked(host string) *hostBucket {
+	b, ok := hl.buckets[host]
+	if !ok {
+		b = &hostBucket{capacity: defaultHostCapacity, rate: defaultHostRate, tokens: defaultHostCapacity, last: time.Now()}
+		hl.buckets[host] = b
+	}
+	return b
+}
+
+// hasCapacity reports whether host currently has a token available, without
+// consuming it; used to prefer such links when ordering the queue.
+func (hl *hostLimiter) hasCapacity(host string) bool {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	b := hl.bucketForLocked(host)
+	b.refill(time.Now())
+	return b.tokens >= 1
+}
+
+// take consumes a token for host, reporting whether one was available.
+func (hl *hostLimiter) take(host string) bool {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	b := hl.bucketForLocked(host)
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// setCrawlDelay narrows host's bucket to honor a robots.txt Crawl-delay.
+// A no-op once the bucket has already been narrowed for that host.
+func (hl *hostLimiter) setCrawlDelay(host string, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	b := hl.bucketForLocked(host)
+	b.rate = 1.0 / delay.Seconds()
+	b.capacity = 1
+	if b.tokens > 1 {
+		b.tokens = 1
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsRules is the subset of a robots.txt file that applies to one
+// user-agent: the path prefixes it may or may not fetch, and an optional
+// crawl delay.
+type robotsRules struct {
+	disallow   []string
+	allow      []string
+	crawlDelay time.Duration
+}
+
+// permits reports whether path is allowed, using the longest-matching-rule
+// wins convention, with Allow breaking ties in its favor.
+func (r *robotsRules) permits(path string) bool {
+	bestLen := -1
+	bestAllow := true
+
+	for _, p := range r.disallow {
+		if p != "" && strings.HasPrefix(path, p) && len(p) > bestLen {
+			bestLen = len(p)
+			bestAllow = false
+		}
+	}
+	for _, p := range r.allow {
+		if p != "" && strings.HasPrefix(path, p) && len(p) >= bestLen {
+			bestLen = len(p)
+			bestAllow = true
+		}
+	}
+	return bestAllow
+}
+
+type robotsGroup struct {
+	agents     []string
+	disallow   []string
+	allow      []string
+	crawlDelay time.Duration
+}
+
+// parseRobots splits a robots.txt body into its user-agent groups.
+func parseRobots(body io.Reader) []robotsGroup {
+	var groups []robotsGroup
+	var cur *robotsGroup
+	inAgents := true
+
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			if cur == nil || !inAgents {
+				groups = append(groups, robotsGroup{})
+				cur = &groups[len(groups)-1]
+			}
+			cur.agents = append(cur.agents, value)
+			inAgents = true
+		case "disallow":
+			if cur != nil {
+				cur.disallow = append(cur.disallow, value)
+				inAgents = false
+			}
+		case "allow":
+			if cur != nil {
+				cur.allow = append(cur.allow, value)
+				inAgents = false
+			}
+		case "crawl-delay":
+			if cur != nil {
+				if secs, err := strconv.ParseFloat(value, 64); err == nil {
+					cur.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+				inAgents = false
+			}
+		}
+	}
+
+	return groups
+}
+
+// rulesForAgent picks the group matching userAgent exactly, falling back to
+// the "*" group, or empty rules when robots.txt has neither.
+func rulesForAgent(groups []robotsGroup, userAgent string) *robotsRules {
+	var wildcard *robotsGroup
+
+	for i := range groups {
+		g := &groups[i]
+		for _, a := range g.agents {
+			if strings.EqualFold(a, userAgent) {
+				return &robotsRules{disallow: g.disallow, allow: g.allow, crawlDelay: g.crawlDelay}
+			}
+			if a == "*" && wildcard == nil {
+				wildcard = g
+			}
+		}
+	}
+	if wildcard != nil {
+		return &robotsRules{disallow: wildcard.disallow, allow: wildcard.allow, crawlDelay: wildcard.crawlDelay}
+	}
+	return &robotsRules{}
+}
+
+// robotsCache fetches and caches robots.txt once per host, and answers
+// whether a given URL may be fetched under userAgent.
+type robotsCache struct {
+	mu        sync.Mutex
+	byHost    map[string]*robotsRules
+	userAgent string
+}
+
+func newRobotsCache(userAgent string) *robotsCache {
+	return &robotsCache{
+		byHost:    make(map[string]*robotsRules),
+		userAgent: userAgent,
+	}
+}
+
+func (rc *robotsCache) rulesFor(u *url.URL) *robotsRules {
+	rc.mu.Lock()
+	if rules, ok := rc.byHost[u.Host]; ok {
+		rc.mu.Unlock()
+		return rules
+	}
+	rc.mu.Unlock()
+
+	rules := rc.fetch(u)
+
+	rc.mu.Lock()
+	rc.byHost[u.Host] = rules
+	rc.mu.Unlock()
+
+	return rules
+}
+
+func (rc *robotsCache) fetch(u *url.URL) *robotsRules {
+	robotsUrl := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+
+	req, err := http.NewRequest(http.MethodGet, robotsUrl.String(), nil)
+	if err != nil {
+		return &robotsRules{}
+	}
+	req.Header.Set("User-Agent", rc.userAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}
+	}
+
+	return rulesForAgent(parseRobots(resp.Body), rc.userAgent)
+}
+
+// allowed reports whether u may be fetched under the crawler's user-agent.
+func (rc *robotsCache) allowed(u *url.URL) bool {
+	return rc.rulesFor(u).permits(u.Path)
+}
+
+func (rc *robotsCache) crawlDelayFor(u *url.URL) time.Duration {
+	return rc.rulesFor(u).crawlDelay
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRobotsRulesPermitsLongestMatchWins(t *testing.T) {
+	r := &robotsRules{
+		disallow: []string{"/private"},
+		allow:    []string{"/private/public"},
+	}
+	if r.permits("/private/secret") {
+		t.Error("expected /private/secret to be disallowed")
+	}
+	if !r.permits("/private/public/page") {
+		t.Error("expected /private/public/page to be allowed (longer Allow match wins)")
+	}
+	if !r.permits("/other") {
+		t.Error("expected /other to be allowed by default")
+	}
+}
+
+func TestRobotsRulesPermitsTieGoesToAllow(t *testing.T) {
+	r := &robotsRules{
+		disallow: []string{"/x"},
+		allow:    []string{"/x"},
+	}
+	if !r.permits("/x") {
+		t.Error("expected equal-length Allow to win ties over Disallow")
+	}
+}
+
+func TestParseRobotsGroupsByUserAgent(t *testing.T) {
+	body := `
+User-agent: GoogleBot
+Disallow: /google-only
+
+User-agent: *
+Disallow: /private
+Allow: /private/ok
+Crawl-delay: 2
+`
+	groups := parseRobots(strings.NewReader(body))
+	if len(groups) != 2 {
+		t.Fatalf("parseRobots() produced %d groups, want 2", len(groups))
+	}
+
+	rules := rulesForAgent(groups, "scraperbot")
+	if !rules.permits("/private/ok") {
+		t.Error("expected /private/ok to be allowed under the wildcard group")
+	}
+	if rules.permits("/private/no") {
+		t.Error("expected /private/no to be disallowed under the wildcard group")
+	}
+	if rules.crawlDelay != 2*time.Second {
+		t.Errorf("crawlDelay = %v, want 2s", rules.crawlDelay)
+	}
+
+	googleRules := rulesForAgent(groups, "GoogleBot")
+	if googleRules.permits("/google-only") {
+		t.Error("expected /google-only to be disallowed for GoogleBot specifically")
+	}
+}
+
+func TestRulesForAgentFallsBackToEmpty(t *testing.T) {
+	rules := rulesForAgent(nil, "scraperbot")
+	if !rules.permits("/anything") {
+		t.Error("expected no robots.txt groups to permit everything")
+	}
+}
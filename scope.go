@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Scope decides whether a primary (non-leaf) link is worth recursing into.
+// Related assets bypass Scope entirely: they're fetched whenever their
+// referring page is in scope, regardless of host.
+type Scope interface {
+	InScope(u *url.URL) bool
+}
+
+// SameHostScope is the crawler's original, hardcoded behavior: stay on the
+// exact host the crawl started from.
+type SameHostScope struct {
+	host string
+}
+
+func (s SameHostScope) InScope(u *url.URL) bool {
+	return u.Host == s.host
+}
+
+// SameDomainScope allows any subdomain of the starting URL's registrable
+// domain (eTLD+1), e.g. docs.example.com and www.example.com both match
+// starting from example.com.
+type SameDomainScope struct {
+	domain string
+}
+
+func (s SameDomainScope) InScope(u *url.URL) bool {
+	d, err := publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+	if err != nil {
+		return false
+	}
+	return d == s.domain
+}
+
+// URLPrefixScope restricts the crawl to URLs under a given prefix, e.g.
+// https://example.com/docs/.
+type URLPrefixScope struct {
+	prefix string
+}
+
+func (s URLPrefixScope) InScope(u *url.URL) bool {
+	return strings.HasPrefix(u.String(), s.prefix)
+}
+
+// RegexpScope restricts the crawl to URLs matching an arbitrary pattern.
+type RegexpScope struct {
+	re *regexp.Regexp
+}
+
+func (s RegexpScope) InScope(u *url.URL) bool {
+	return s.re.MatchString(u.String())
+}
+
+// newScope parses the -scope flag value into a Scope. "samehost" (the
+// default) and "samedomain" take no argument; "prefix:<p>" and
+// "regexp:<pattern>" take theirs after the colon.
+func newScope(spec string, mainUrl *url.URL) (Scope, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+
+	switch kind {
+	case "", "samehost":
+		return SameHostScope{host: mainUrl.Host}, nil
+	case "samedomain":
+		domain, err := publicsuffix.EffectiveTLDPlusOne(mainUrl.Hostname())
+		if err != nil {
+			return nil, fmt.Errorf("determining domain for %s: %w", mainUrl.Host, err)
+		}
+		return SameDomainScope{domain: domain}, nil
+	case "prefix":
+		if arg == "" {
+			return nil, fmt.Errorf("scope %q needs a prefix, e.g. prefix:https://example.com/docs/", spec)
+		}
+		return URLPrefixScope{prefix: arg}, nil
+	case "regexp":
+		if arg == "" {
+			return nil, fmt.Errorf("scope %q needs a pattern, e.g. regexp:^https://docs\\.", spec)
+		}
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return RegexpScope{re: re}, nil
+	default:
+		return nil, fmt.Errorf("unknown scope %q: want samehost, samedomain, prefix:<p> or regexp:<pattern>", spec)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("url.Parse(%q): %v", raw, err)
+	}
+	return u
+}
+
+func TestSameHostScope(t *testing.T) {
+	s := SameHostScope{host: "example.com"}
+	if !s.InScope(mustParseURL(t, "https://example.com/page")) {
+		t.Error("expected same host to be in scope")
+	}
+	if s.InScope(mustParseURL(t, "https://docs.example.com/page")) {
+		t.Error("expected a different subdomain to be out of scope")
+	}
+}
+
+func TestSameDomainScope(t *testing.T) {
+	s := SameDomainScope{domain: "example.com"}
+	if !s.InScope(mustParseURL(t, "https://docs.example.com/page")) {
+		t.Error("expected a subdomain to be in scope")
+	}
+	if !s.InScope(mustParseURL(t, "https://example.com/page")) {
+		t.Error("expected the apex domain to be in scope")
+	}
+	if s.InScope(mustParseURL(t, "https://example.org/page")) {
+		t.Error("expected a different registrable domain to be out of scope")
+	}
+}
+
+func TestURLPrefixScope(t *testing.T) {
+	s := URLPrefixScope{prefix: "https://example.com/docs/"}
+	if !s.InScope(mustParseURL(t, "https://example.com/docs/manual")) {
+		t.Error("expected a URL under the prefix to be in scope")
+	}
+	if s.InScope(mustParseURL(t, "https://example.com/blog")) {
+		t.Error("expected a URL outside the prefix to be out of scope")
+	}
+}
+
+func TestRegexpScope(t *testing.T) {
+	s, err := newScope("regexp:^https://docs\\.", mustParseURL(t, "https://docs.example.com/"))
+	if err != nil {
+		t.Fatalf("newScope() error: %v", err)
+	}
+	if !s.InScope(mustParseURL(t, "https://docs.example.com/page")) {
+		t.Error("expected a matching URL to be in scope")
+	}
+	if s.InScope(mustParseURL(t, "https://example.com/page")) {
+		t.Error("expected a non-matching URL to be out of scope")
+	}
+}
+
+func TestNewScopeDefaultsToSameHost(t *testing.T) {
+	main := mustParseURL(t, "https://example.com/")
+	s, err := newScope("", main)
+	if err != nil {
+		t.Fatalf("newScope() error: %v", err)
+	}
+	if _, ok := s.(SameHostScope); !ok {
+		t.Fatalf("newScope(\"\") = %T, want SameHostScope", s)
+	}
+}
+
+func TestNewScopeUnknownKind(t *testing.T) {
+	if _, err := newScope("bogus", mustParseURL(t, "https://example.com/")); err == nil {
+		t.Fatal("expected newScope() to reject an unknown scope kind")
+	}
+}
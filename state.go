@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// urlStatus tracks where a URL sits in the crawl lifecycle.
+type urlStatus int
+
+const (
+	StatusQueued urlStatus = iota
+	StatusInFlight
+	StatusDone
+	StatusFailed
+)
+
+var urlsBucket = []byte("urls")
+
+// hashesBucket maps a SHA-256 content hash to the local path it was first
+// saved under, so a later URL with identical content can be recorded
+// without writing a duplicate file.
+var hashesBucket = []byte("hashes")
+
+// urlRecord is the persisted state for a single URL: enough to resume a
+// crawl without re-fetching anything already marked done.
+type urlRecord struct {
+	Status       urlStatus
+	Attempts     int
+	LastFetch    time.Time
+	Depth        int
+	ContentHash  string
+	IsLeaf       bool
+	Tag          LinkTag
+	ETag         string
+	LastModified time.Time
+}
+
+// crawlState is a bbolt-backed store mapping URL -> urlRecord. It replaces
+// the scheduler's in-memory seen map and initial queue when -state is set,
+// so a crawl can be interrupted and resumed without re-downloading anything
+// already marked done.
+type crawlState struct {
+	db *bolt.DB
+}
+
+func openCrawlState(dir string) (*crawlState, error) {
+	db, err := bolt.Open(filepath.Join(dir, "crawl.db"), 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(urlsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(hashesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &crawlState{db: db}, nil
+}
+
+func (cs *crawlState) Close() error {
+	return cs.db.Close()
+}
+
+func (cs *crawlState) get(key string) (*urlRecord, bool, error) {
+	var rec *urlRecord
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(urlsBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		rec = &urlRecord{}
+		return json.Unmarshal(v, rec)
+	})
+	return rec, rec != nil, err
+}
+
+func (cs *crawlState) put(key string, rec *urlRecord) error {
+	v, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return cs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(urlsBucket).Put([]byte(key), v)
+	})
+}
+
+// markQueued records a newly discovered link, if its URL isn't already
+// known, and reports whether it was newly inserted.
+func (cs *crawlState) markQueued(lk *Link) (bool, error) {
+	key := lk.url.String()
+	_, found, err := cs.get(key)
+	if err != nil || found {
+		return false, err
+	}
+	err = cs.put(key, &urlRecord{
+		Status: StatusQueued,
+		IsLeaf: lk.isLeaf,
+		Tag:    lk.tag,
+		Depth:  lk.depth,
+	})
+	return err == nil, err
+}
+
+func (cs *crawlState) markInFlight(urlStr string) error {
+	rec, _, err := cs.get(urlStr)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		rec = &urlRecord{}
+	}
+	rec.Status = StatusInFlight
+	rec.Attempts++
+	rec.LastFetch = time.Now()
+	return cs.put(urlStr, rec)
+}
+
+func (cs *crawlState) markDone(urlStr string) error {
+	rec, _, err := cs.get(urlStr)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		rec = &urlRecord{}
+	}
+	rec.Status = StatusDone
+	return cs.put(urlStr, rec)
+}
+
+func (cs *crawlState) markFailed(urlStr string) error {
+	rec, _, err := cs.get(urlStr)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		rec = &urlRecord{}
+	}
+	rec.Status = StatusFailed
+	return cs.put(urlStr, rec)
+}
+
+// recordFetch stores the caching metadata and content hash from a
+// successful fetch, for use as conditional-request headers and dedup on
+// later runs.
+func (cs *crawlState) recordFetch(urlStr, etag string, lastModified time.Time, contentHash string) error {
+	rec, _, err := cs.get(urlStr)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		rec = &urlRecord{}
+	}
+	rec.ETag = etag
+	rec.LastModified = lastModified
+	rec.ContentHash = contentHash
+	return cs.put(urlStr, rec)
+}
+
+// pathForHash returns the local path previously recorded for a content
+// hash, if any, so a duplicate fetch can be skipped.
+func (cs *crawlState) pathForHash(hash string) (string, bool, error) {
+	var localPath string
+	found := false
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(hashesBucket).Get([]byte(hash))
+		found = v != nil
+		localPath = string(v)
+		return nil
+	})
+	return localPath, found, err
+}
+
+// recordHashPath records that hash's content was saved at localPath, so
+// later URLs with the same content can be deduped against it.
+func (cs *crawlState) recordHashPath(hash, localPath string) error {
+	return cs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(hashesBucket).Put([]byte(hash), []byte(localPath))
+	})
+}
+
+// claimHash atomically checks whether hash has already been saved and, if
+// not, reserves it with a placeholder entry in the same bbolt transaction.
+// It reports whether this call won the claim, so the caller knows it's the
+// one responsible for writing the file to disk (via a later recordHashPath)
+// rather than just recording a dedup hit; this closes the TOCTOU window a
+// separate pathForHash-then-recordHashPath pair would leave between
+// concurrent workers fetching byte-identical content.
+func (cs *crawlState) claimHash(hash string) (bool, error) {
+	claimed := false
+	err := cs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hashesBucket)
+		if b.Get([]byte(hash)) != nil {
+			return nil
+		}
+		claimed = true
+		return b.Put([]byte(hash), []byte(""))
+	})
+	return claimed, err
+}
+
+// pending returns a Link for every known URL not marked done, so a resumed
+// crawl re-enqueues in-flight and failed work instead of starting over.
+func (cs *crawlState) pending() ([]*Link, error) {
+	var links []*Link
+	err := cs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(urlsBucket).ForEach(func(k, v []byte) error {
+			var rec urlRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if rec.Status == StatusDone {
+				return nil
+			}
+			u, err := url.Parse(string(k))
+			if err != nil {
+				return err
+			}
+			links = append(links, &Link{
+				url:    u,
+				isLeaf: rec.IsLeaf,
+				tag:    rec.Tag,
+				depth:  rec.Depth,
+			})
+			return nil
+		})
+	})
+	return links, err
+}
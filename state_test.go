@@ -0,0 +1,188 @@
+package main
+
+import "testing"
+
+func openTestState(t *testing.T) *crawlState {
+	t.Helper()
+	cs, err := openCrawlState(t.TempDir())
+	if err != nil {
+		t.Fatalf("openCrawlState() error: %v", err)
+	}
+	t.Cleanup(func() { cs.Close() })
+	return cs
+}
+
+func TestMarkQueuedThenInFlightThenDone(t *testing.T) {
+	cs := openTestState(t)
+	lk := &Link{url: mustParseURL(t, "https://example.com/page"), isLeaf: false, tag: TagPrimary, depth: 2}
+
+	queued, err := cs.markQueued(lk)
+	if err != nil {
+		t.Fatalf("markQueued() error: %v", err)
+	}
+	if !queued {
+		t.Fatal("markQueued() = false, want true for a newly discovered URL")
+	}
+
+	queued, err = cs.markQueued(lk)
+	if err != nil {
+		t.Fatalf("markQueued() error: %v", err)
+	}
+	if queued {
+		t.Fatal("markQueued() = true on a second call, want false (already known)")
+	}
+
+	if err := cs.markInFlight(lk.url.String()); err != nil {
+		t.Fatalf("markInFlight() error: %v", err)
+	}
+	rec, found, err := cs.get(lk.url.String())
+	if err != nil || !found {
+		t.Fatalf("get() after markInFlight: rec=%v found=%v err=%v", rec, found, err)
+	}
+	if rec.Status != StatusInFlight || rec.Attempts != 1 {
+		t.Errorf("after markInFlight: status=%v attempts=%d, want InFlight/1", rec.Status, rec.Attempts)
+	}
+
+	if err := cs.markDone(lk.url.String()); err != nil {
+		t.Fatalf("markDone() error: %v", err)
+	}
+	rec, _, err = cs.get(lk.url.String())
+	if err != nil {
+		t.Fatalf("get() after markDone: %v", err)
+	}
+	if rec.Status != StatusDone {
+		t.Errorf("status after markDone = %v, want StatusDone", rec.Status)
+	}
+	// IsLeaf/Tag/Depth recorded at markQueued time must survive later updates.
+	if rec.IsLeaf != false || rec.Tag != TagPrimary || rec.Depth != 2 {
+		t.Errorf("rec = %+v, want IsLeaf=false Tag=TagPrimary Depth=2", rec)
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	cs := openTestState(t)
+	lk := &Link{url: mustParseURL(t, "https://example.com/flaky")}
+	if _, err := cs.markQueued(lk); err != nil {
+		t.Fatalf("markQueued() error: %v", err)
+	}
+	if err := cs.markFailed(lk.url.String()); err != nil {
+		t.Fatalf("markFailed() error: %v", err)
+	}
+	rec, _, err := cs.get(lk.url.String())
+	if err != nil {
+		t.Fatalf("get() error: %v", err)
+	}
+	if rec.Status != StatusFailed {
+		t.Errorf("status = %v, want StatusFailed", rec.Status)
+	}
+}
+
+func TestPendingExcludesDoneAndRestoresFields(t *testing.T) {
+	cs := openTestState(t)
+
+	done := &Link{url: mustParseURL(t, "https://example.com/done")}
+	queued := &Link{url: mustParseURL(t, "https://example.com/queued"), isLeaf: true, tag: TagRelated, depth: 3}
+	failed := &Link{url: mustParseURL(t, "https://example.com/failed")}
+
+	for _, lk := range []*Link{done, queued, failed} {
+		if _, err := cs.markQueued(lk); err != nil {
+			t.Fatalf("markQueued(%s) error: %v", lk.url, err)
+		}
+	}
+	if err := cs.markDone(done.url.String()); err != nil {
+		t.Fatalf("markDone() error: %v", err)
+	}
+	if err := cs.markFailed(failed.url.String()); err != nil {
+		t.Fatalf("markFailed() error: %v", err)
+	}
+
+	links, err := cs.pending()
+	if err != nil {
+		t.Fatalf("pending() error: %v", err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("pending() returned %d links, want 2 (done URL excluded)", len(links))
+	}
+
+	byURL := make(map[string]*Link, len(links))
+	for _, lk := range links {
+		byURL[lk.url.String()] = lk
+	}
+	if _, ok := byURL[done.url.String()]; ok {
+		t.Error("pending() included a URL marked done")
+	}
+	restored, ok := byURL[queued.url.String()]
+	if !ok {
+		t.Fatal("pending() did not include the still-queued URL")
+	}
+	if restored.isLeaf != true || restored.tag != TagRelated || restored.depth != 3 {
+		t.Errorf("restored link = %+v, want isLeaf=true tag=TagRelated depth=3", restored)
+	}
+	if _, ok := byURL[failed.url.String()]; !ok {
+		t.Error("pending() did not include a failed URL for retry")
+	}
+}
+
+func TestPathForHashAndRecordHashPath(t *testing.T) {
+	cs := openTestState(t)
+
+	if _, found, err := cs.pathForHash("deadbeef"); err != nil || found {
+		t.Fatalf("pathForHash() on unknown hash = found=%v err=%v, want found=false", found, err)
+	}
+
+	if err := cs.recordHashPath("deadbeef", "assets/deadbeef.png"); err != nil {
+		t.Fatalf("recordHashPath() error: %v", err)
+	}
+
+	path, found, err := cs.pathForHash("deadbeef")
+	if err != nil {
+		t.Fatalf("pathForHash() error: %v", err)
+	}
+	if !found || path != "assets/deadbeef.png" {
+		t.Errorf("pathForHash() = (%q, %v), want (\"assets/deadbeef.png\", true)", path, found)
+	}
+}
+
+func TestClaimHashOnlyOneWinner(t *testing.T) {
+	cs := openTestState(t)
+
+	claimed, err := cs.claimHash("abc123")
+	if err != nil {
+		t.Fatalf("claimHash() error: %v", err)
+	}
+	if !claimed {
+		t.Fatal("claimHash() on a fresh hash = false, want true")
+	}
+
+	claimed, err = cs.claimHash("abc123")
+	if err != nil {
+		t.Fatalf("claimHash() error: %v", err)
+	}
+	if claimed {
+		t.Fatal("claimHash() on an already-claimed hash = true, want false")
+	}
+
+	if _, found, err := cs.pathForHash("abc123"); err != nil || !found {
+		t.Fatalf("pathForHash() after claimHash() = found=%v err=%v, want found=true", found, err)
+	}
+}
+
+func TestRecordFetch(t *testing.T) {
+	cs := openTestState(t)
+	lk := &Link{url: mustParseURL(t, "https://example.com/cacheable")}
+	if _, err := cs.markQueued(lk); err != nil {
+		t.Fatalf("markQueued() error: %v", err)
+	}
+
+	if err := cs.recordFetch(lk.url.String(), "W/\"abc\"", parseLastModified(""), "hash123"); err != nil {
+		t.Fatalf("recordFetch() error: %v", err)
+	}
+
+	rec, found, err := cs.get(lk.url.String())
+	if err != nil || !found {
+		t.Fatalf("get() error=%v found=%v", err, found)
+	}
+	if rec.ETag != "W/\"abc\"" || rec.ContentHash != "hash123" {
+		t.Errorf("rec = %+v, want ETag=W/\\\"abc\\\" ContentHash=hash123", rec)
+	}
+}
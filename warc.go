@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// warcWriter serializes HTTP request/response pairs into a single gzip-compressed
+// WARC file, one member per record, so the result can be replayed by tools like
+// pywb or wayback.
+type warcWriter struct {
+	mu   sync.Mutex
+	f    *os.File
+	gz   *gzip.Writer
+	open bool // true while gz has an unclosed member, i.e. between Reset and Close
+}
+
+func newWarcWriter(filename string) (*warcWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	ww := &warcWriter{
+		f:    f,
+		gz:   gzip.NewWriter(f),
+		open: true,
+	}
+
+	if err := ww.writeWarcinfo(); err != nil {
+		ww.Close()
+		return nil, err
+	}
+
+	return ww, nil
+}
+
+func (ww *warcWriter) writeWarcinfo() error {
+	body := []byte("software: uwedeportivo/scraper\nformat: WARC File Format 1.1\n")
+
+	header := fmt.Sprintf(
+		"WARC/1.1\r\n"+
+			"WARC-Type: warcinfo\r\n"+
+			"WARC-Record-ID: <urn:uuid:%s>\r\n"+
+			"WARC-Date: %s\r\n"+
+			"Content-Type: application/warc-fields\r\n"+
+			"Content-Length: %d\r\n"+
+			"\r\n",
+		uuid.NewString(), warcDate(), len(body))
+
+	return ww.writeRecord(header, body)
+}
+
+// writeExchange appends a request record followed by its matching response
+// record for the given url, each naming the other's WARC-Record-ID in its
+// WARC-Concurrent-To so replay tools can pair them up.
+func (ww *warcWriter) writeExchange(urlStr string, req *http.Request, resp *http.Response, body []byte) error {
+	reqID := uuid.NewString()
+	respID := uuid.NewString()
+	date := warcDate()
+
+	reqBytes, err := httputil.DumpRequestOut(req, false)
+	if err != nil {
+		return err
+	}
+
+	reqHeader := fmt.Sprintf(
+		"WARC/1.1\r\n"+
+			"WARC-Type: request\r\n"+
+			"WARC-Record-ID: <urn:uuid:%s>\r\n"+
+			"WARC-Concurrent-To: <urn:uuid:%s>\r\n"+
+			"WARC-Target-URI: %s\r\n"+
+			"WARC-Date: %s\r\n"+
+			"Content-Type: application/http; msgtype=request\r\n"+
+			"Content-Length: %d\r\n"+
+			"\r\n",
+		reqID, respID, urlStr, date, len(reqBytes))
+
+	if err := ww.writeRecord(reqHeader, reqBytes); err != nil {
+		return err
+	}
+
+	respBytes, err := dumpResponseHeader(resp)
+	if err != nil {
+		return err
+	}
+	respBytes = append(respBytes, body...)
+
+	respHeader := fmt.Sprintf(
+		"WARC/1.1\r\n"+
+			"WARC-Type: response\r\n"+
+			"WARC-Record-ID: <urn:uuid:%s>\r\n"+
+			"WARC-Concurrent-To: <urn:uuid:%s>\r\n"+
+			"WARC-Target-URI: %s\r\n"+
+			"WARC-Date: %s\r\n"+
+			"Content-Type: application/http; msgtype=response\r\n"+
+			"Content-Length: %d\r\n"+
+			"\r\n",
+		respID, reqID, urlStr, date, len(respBytes))
+
+	return ww.writeRecord(respHeader, respBytes)
+}
+
+func (ww *warcWriter) writeRecord(header string, body []byte) error {
+	ww.mu.Lock()
+	defer ww.mu.Unlock()
+
+	if !ww.open {
+		ww.gz.Reset(ww.f)
+		ww.open = true
+	}
+
+	if _, err := io.WriteString(ww.gz, header); err != nil {
+		return err
+	}
+	if _, err := ww.gz.Write(body); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(ww.gz, "\r\n\r\n"); err != nil {
+		return err
+	}
+	// Closing here finishes this member's CRC/size trailer so it stands on
+	// its own as a complete gzip member; the next writeRecord call starts a
+	// fresh one, giving one gzip member per record as WARC.gz requires.
+	if err := ww.gz.Close(); err != nil {
+		return err
+	}
+	ww.open = false
+	return nil
+}
+
+func (ww *warcWriter) Close() error {
+	if ww.open {
+		if err := ww.gz.Close(); err != nil {
+			ww.f.Close()
+			return err
+		}
+		ww.open = false
+	}
+	return ww.f.Close()
+}
+
+func warcDate() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// dumpResponseHeader renders the status line and headers of resp the way they
+// appeared on the wire, without consuming resp.Body.
+func dumpResponseHeader(resp *http.Response) ([]byte, error) {
+	clone := *resp
+	clone.Body = http.NoBody
+	clone.ContentLength = -1
+	return httputil.DumpResponse(&clone, false)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readMember decodes the current gzip member from gz, which stops at its
+// boundary since gz.Multistream(false) rather than chaining into the next
+// member, so each record's compressed member can be checked independently.
+func readMember(t *testing.T, gz *gzip.Reader) string {
+	t.Helper()
+	content, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("decoding gzip member: %v", err)
+	}
+	return string(content)
+}
+
+func TestWarcWriterOneGzipMemberPerRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "out.warc.gz")
+
+	ww, err := newWarcWriter(filename)
+	if err != nil {
+		t.Fatalf("newWarcWriter() error: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/page", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "text/html")
+	rec.WriteHeader(http.StatusOK)
+	resp := rec.Result()
+
+	body := []byte("<html><body>hi</body></html>")
+	if err := ww.writeExchange("http://example.com/page", req, resp, body); err != nil {
+		t.Fatalf("writeExchange() error: %v", err)
+	}
+	if err := ww.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+
+	br := bytes.NewReader(data)
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error: %v", err)
+	}
+	gz.Multistream(false)
+
+	warcinfoContent := readMember(t, gz)
+	if !strings.Contains(warcinfoContent, "WARC-Type: warcinfo") {
+		t.Errorf("first member = %q, want a warcinfo record", warcinfoContent)
+	}
+
+	if err := gz.Reset(br); err != nil {
+		t.Fatalf("advancing to the request member: %v", err)
+	}
+	gz.Multistream(false)
+	reqContent := readMember(t, gz)
+	if !strings.Contains(reqContent, "WARC-Type: request") {
+		t.Errorf("second member = %q, want a request record", reqContent)
+	}
+
+	if err := gz.Reset(br); err != nil {
+		t.Fatalf("advancing to the response member: %v", err)
+	}
+	gz.Multistream(false)
+	respContent := readMember(t, gz)
+	if !strings.Contains(respContent, "WARC-Type: response") {
+		t.Errorf("third member = %q, want a response record", respContent)
+	}
+	if !strings.Contains(respContent, "hi</body>") {
+		t.Errorf("response member missing the fetched body: %q", respContent)
+	}
+
+	// Close must not leave a trailing empty gzip member behind: exactly
+	// warcinfo + request + response, nothing more.
+	if err := gz.Reset(br); err != io.EOF {
+		t.Errorf("gz.Reset() after the last record = %v, want io.EOF (no extra trailing member)", err)
+	}
+}